@@ -5,7 +5,7 @@
 # with matched concurrency + duration, so the comparison is apples-to-apples.
 # Run the engine daemon and mock-server first. Results print as a markdown table.
 #
-#   go run scripts/test/mock-server.go &            # mock on :8080
+#   go run scripts/test/mock-server.go &            # mock on :8080 (requires Go 1.24+)
 #   engine/build/vayu-engine --port 9876 --data-dir engine/data &
 #   bash scripts/test/bench-compare.sh
 set -euo pipefail
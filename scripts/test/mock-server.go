@@ -1,44 +1,2243 @@
 // High-performance mock server for benchmarking Vayu
 // Responds instantly with minimal latency to test true throughput capacity
 //
-// Usage: go run mock-server.go
+// Usage: go run mock-server.go [-config routes.json] [-tls [-cert c.pem -key k.pem]]
+// Requires Go 1.24+ (http.Protocols); there is no go.mod to enforce it.
 // Default port: 8080
 // Endpoints:
 //   GET  /health     - Health check (instant response)
 //   GET  /fast       - Fast endpoint (~0ms latency)
-//   GET  /slow/:ms   - Configurable delay (e.g., /slow/100 for 100ms)
+//   GET  /slow/:ms   - Configurable delay (e.g., /slow/100 for 100ms,
+//                      /slow/100?jitter=30 for 70-130ms,
+//                      /slow/100?dist=lognormal&sigma=0.8 for a long tail)
 //   POST /echo       - Echo back request body
-//   GET  /stats      - Show request statistics
+//   GET  /stats      - Show request statistics, overall and per route
+//                      pattern (count, RPS, avg latency, status codes)
+//   GET  /metrics    - Prometheus metrics (per-path counts by status code,
+//                      latency histograms, in-flight requests)
+//   GET  /requests   - Requests recorded with -record N (?method=, ?path=,
+//                      ?limit=); DELETE clears them
+//   GET  /ws/echo    - WebSocket echo
+//   GET  /ws/broadcast - WebSocket fan-out at -ws-rate messages/sec; client
+//                      messages are relayed to every subscriber
+//   *    /api/:resource[/:id] - In-memory CRUD store (POST, GET, PUT, PATCH,
+//                      DELETE); config routes under /api take precedence
+//   GET  /bytes/:n   - N-byte body (?fill=random|pattern, ?gzip=1)
+//   GET  /sse/:ms    - Server-sent events every ms (?count=N to stop after N)
+//   POST /vayu.mock.v1.Echo/* - gRPC echo service (Unary, ServerStream,
+//                      ClientStream, BidiStream; see mock-echo.proto).
+//                      Needs HTTP/2, so start with -tls or -h2c.
+//
+// Additional routes can be declared in a JSON file passed via -config (see
+// mock-routes.example.json). Each route sets a method, path pattern, status,
+// headers, body and optional delay; patterns use net/http ServeMux syntax.
+// A route's "faults" block (or a top-level one for every other endpoint
+// except /stats, /reset, /requests and /metrics) injects error statuses,
+// connection resets and hangs at the given rates. With "template": true the body and
+// header values are Go text/templates over the request, e.g.
+// {{.Params.id}}, {{.Query.Get "q"}}, {{.Header.Get "X-Request-Id"}},
+// {{.Body.user.name}} and {{json .Body.items}}. A missing key fails the
+// request with a 500; use {{index .Body "key"}} for optional fields.
+//
+// With -tls the server speaks HTTPS and negotiates HTTP/2 via ALPN (disable
+// with -h2=false). Without -cert/-key a self-signed certificate for
+// localhost and the listen host is generated at startup; -write-cert saves
+// it so clients can trust it. -h2c serves cleartext HTTP/2 instead.
+//
+// -conn-bandwidth caps how fast each connection is written to (e.g. 1MB or
+// 512KiB per second), for slow-download and read-timeout testing.
+//
+// -max-concurrent caps requests in progress; beyond it the server answers
+// 503 with Retry-After, or with -overload=queue waits up to -queue-timeout
+// for a slot first. Open WebSocket, SSE and gRPC streams hold a slot.
+//
+// -rate-limit R allows R requests/sec (bursts of -rate-burst) per client IP,
+// route, or IP and route (-rate-key); beyond it the server answers 429 with
+// Retry-After and X-RateLimit-* headers.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"math"
+	"math/big"
+	mrand "math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	totalRequests   int64
+	totalLatencyNs  int64
+	totalFaults     int64
+	wsConnections   int64
+	sseConnections  int64
+	totalOverloaded int64
+	totalLimited    int64
+	startTime       atomic.Pointer[time.Time] // set at startup and by /reset
+	requestsPerPath = newMetricsRegistry()    // per-path /stats and /metrics
+)
+
+// routeConfig is a single mock route declared in the -config file.
+type routeConfig struct {
+	Method  string            `json:"method"`
+	Path    string            `json:"path"`
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body"`
+	DelayMs int               `json:"delayMs"`
+	Latency *latencyConfig    `json:"latency"`
+	Faults  *faultConfig      `json:"faults"`
+
+	// Template renders Body and header values per request (see templateData).
+	Template bool `json:"template"`
+
+	bodyTmpl    *template.Template
+	headerTmpls map[string]*template.Template
+	params      []string
+}
+
+// templateData is what route templates see.
+type templateData struct {
+	Method  string
+	Path    string
+	Params  map[string]string // path wildcards, e.g. {id}
+	Query   url.Values
+	Header  http.Header
+	Body    any    // request body decoded as JSON; an empty object otherwise
+	RawBody string // request body as sent
+}
+
+// templateFuncs are available to route templates. json renders a value as
+// JSON (strings come out quoted), so it is the safe way to splice request
+// data into a JSON body.
+var templateFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"now": func() string { return time.Now().UTC().Format(time.RFC3339Nano) },
+	"uuid": func() string {
+		var b [16]byte
+		rand.Read(b[:])
+		b[6] = b[6]&0x0f | 0x40
+		b[8] = b[8]&0x3f | 0x80
+		return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+	},
+}
+
+// wildcardRE matches ServeMux wildcards such as {id} or {rest...}.
+var wildcardRE = regexp.MustCompile(`\{([^{}.]+)(?:\.\.\.)?\}`)
+
+// newRouteTemplate parses a route template. A missing map key, such as
+// {{.Body.name}} on a body without "name", fails the request with a 500
+// instead of rendering "<no value>"; {{index .Body "name"}} is the way to
+// reference an optional field (it yields null through json).
+func newRouteTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+}
+
+// compileTemplates parses the route's body and header templates.
+func (rc *routeConfig) compileTemplates() error {
+	var err error
+	if rc.bodyTmpl, err = newRouteTemplate("body", rc.Body); err != nil {
+		return err
+	}
+	rc.headerTmpls = make(map[string]*template.Template, len(rc.Headers))
+	for k, v := range rc.Headers {
+		if rc.headerTmpls[k], err = newRouteTemplate(k, v); err != nil {
+			return err
+		}
+	}
+	for _, m := range wildcardRE.FindAllStringSubmatch(rc.Path, -1) {
+		rc.params = append(rc.params, m[1])
+	}
+	return nil
+}
+
+// templateDataFor collects the request fields templates can reference.
+func (rc *routeConfig) templateDataFor(r *http.Request) templateData {
+	td := templateData{
+		Method: r.Method,
+		Path:   r.URL.Path,
+		Params: make(map[string]string, len(rc.params)),
+		Query:  r.URL.Query(),
+		Header: r.Header,
+	}
+	for _, name := range rc.params {
+		td.Params[name] = r.PathValue(name)
+	}
+	if r.Body != nil {
+		raw, _ := io.ReadAll(io.LimitReader(r.Body, maxCRUDBody))
+		td.RawBody = string(raw)
+		if len(raw) > 0 {
+			dec := json.NewDecoder(bytes.NewReader(raw))
+			dec.UseNumber()
+			if dec.Decode(&td.Body) != nil {
+				td.Body = nil
+			}
+		}
+	}
+	// With an empty object, {{.Body.field}} on a missing or non-JSON body
+	// reports the missing key rather than a nil pointer.
+	if td.Body == nil {
+		td.Body = map[string]any{}
+	}
+	return td
+}
+
+// latencyConfig describes a delay distribution. All times are in ms; which
+// fields apply depends on Distribution:
+//
+//	fixed      meanMs
+//	normal     meanMs, stddevMs
+//	lognormal  medianMs, sigma
+//	pareto     minMs, alpha
+//	bimodal    meanMs, slowMs, slowRatio, stddevMs (spread of both modes)
+//
+// jitterMs adds a uniform offset in [-jitterMs, +jitterMs] to every sample.
+// maxMs, when set, caps every sample so a heavy tail cannot stall a client
+// beyond its timeout.
+type latencyConfig struct {
+	Distribution string  `json:"distribution"`
+	MeanMs       float64 `json:"meanMs"`
+	StddevMs     float64 `json:"stddevMs"`
+	MedianMs     float64 `json:"medianMs"`
+	Sigma        float64 `json:"sigma"`
+	MinMs        float64 `json:"minMs"`
+	Alpha        float64 `json:"alpha"`
+	SlowMs       float64 `json:"slowMs"`
+	SlowRatio    float64 `json:"slowRatio"`
+	JitterMs     float64 `json:"jitterMs"`
+	MaxMs        float64 `json:"maxMs"`
+}
+
+func (lc *latencyConfig) validate() error {
+	switch lc.Distribution {
+	case "fixed", "normal":
+	case "lognormal":
+		if lc.MedianMs <= 0 {
+			return errors.New("lognormal requires medianMs > 0")
+		}
+	case "pareto":
+		if lc.MinMs <= 0 || lc.Alpha <= 0 {
+			return errors.New("pareto requires minMs > 0 and alpha > 0")
+		}
+	case "bimodal":
+		if lc.SlowRatio < 0 || lc.SlowRatio > 1 {
+			return errors.New("bimodal requires slowRatio in [0, 1]")
+		}
+	default:
+		return fmt.Errorf("unknown distribution %q", lc.Distribution)
+	}
+	if lc.MeanMs < 0 || lc.StddevMs < 0 || lc.Sigma < 0 || lc.SlowMs < 0 || lc.JitterMs < 0 || lc.MaxMs < 0 {
+		return errors.New("latency parameters must not be negative")
+	}
+	return nil
+}
+
+// sample draws one delay from the distribution.
+func (lc *latencyConfig) sample() time.Duration {
+	var ms float64
+	switch lc.Distribution {
+	case "fixed":
+		ms = lc.MeanMs
+	case "normal":
+		ms = lc.MeanMs + mrand.NormFloat64()*lc.StddevMs
+	case "lognormal":
+		ms = lc.MedianMs * math.Exp(mrand.NormFloat64()*lc.Sigma)
+	case "pareto":
+		ms = lc.MinMs / math.Pow(1-mrand.Float64(), 1/lc.Alpha)
+	case "bimodal":
+		ms = lc.MeanMs
+		if mrand.Float64() < lc.SlowRatio {
+			ms = lc.SlowMs
+		}
+		ms += mrand.NormFloat64() * lc.StddevMs
+	}
+	if lc.JitterMs > 0 {
+		ms += (mrand.Float64()*2 - 1) * lc.JitterMs
+	}
+	if lc.MaxMs > 0 {
+		ms = min(ms, lc.MaxMs)
+	}
+	if ms <= 0 {
+		return 0
+	}
+	return time.Duration(ms * float64(time.Millisecond))
+}
+
+// latencyFromQuery builds the /slow distribution from its path delay and
+// query parameters. The path delay is the distribution's central value: the
+// mean for normal, the median for lognormal, the minimum for pareto and the
+// fast mode for bimodal. A negative delay counts as 0, as a plain /slow/-5
+// always has; lognormal and pareto need a positive scale, so with a delay of
+// 0 their median and minimum fall back to 1ms.
+func latencyFromQuery(delayMs int, q url.Values) (*latencyConfig, error) {
+	num := func(key string, def float64) (float64, error) {
+		v := q.Get(key)
+		if v == "" {
+			return def, nil
+		}
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s %q", key, v)
+		}
+		return f, nil
+	}
+
+	lc := &latencyConfig{Distribution: q.Get("dist")}
+	if lc.Distribution == "" {
+		lc.Distribution = "fixed"
+	}
+	base := float64(max(delayMs, 0))
+	lc.MeanMs, lc.MedianMs, lc.MinMs = base, base, base
+	if base == 0 {
+		lc.MedianMs, lc.MinMs = 1, 1
+	}
+
+	// A bare ?dist=normal gets a visible spread; bimodal modes stay sharp
+	// unless a stddev is given.
+	defStddev := 0.0
+	if lc.Distribution == "normal" {
+		defStddev = base / 4
+	}
+
+	var err error
+	if lc.StddevMs, err = num("stddev", defStddev); err != nil {
+		return nil, err
+	}
+	if lc.Sigma, err = num("sigma", 0.5); err != nil {
+		return nil, err
+	}
+	if lc.Alpha, err = num("alpha", 1.5); err != nil {
+		return nil, err
+	}
+	if lc.SlowMs, err = num("slow", base*10); err != nil {
+		return nil, err
+	}
+	if lc.SlowRatio, err = num("ratio", 0.05); err != nil {
+		return nil, err
+	}
+	if lc.JitterMs, err = num("jitter", 0); err != nil {
+		return nil, err
+	}
+	if lc.MaxMs, err = num("max", 0); err != nil {
+		return nil, err
+	}
+	return lc, lc.validate()
+}
+
+// mockConfig is the top-level shape of the -config file. Faults applies to
+// every endpoint that is not a config route with its own faults block.
+type mockConfig struct {
+	Routes []routeConfig `json:"routes"`
+	Faults *faultConfig  `json:"faults"`
+}
+
+// faultConfig injects failures at the given rates (0..1). A hang stalls for
+// hangMs and then drops the connection without a response, so clients see
+// their own read timeout or an EOF.
+type faultConfig struct {
+	ErrorRate   float64 `json:"errorRate"`
+	ErrorStatus int     `json:"errorStatus"`
+	ResetRate   float64 `json:"resetRate"`
+	HangRate    float64 `json:"hangRate"`
+	HangMs      int     `json:"hangMs"`
+}
+
+func (fc *faultConfig) validate() error {
+	for _, r := range []float64{fc.ErrorRate, fc.ResetRate, fc.HangRate} {
+		if r < 0 || r > 1 {
+			return errors.New("rates must be in [0, 1]")
+		}
+	}
+	if fc.ErrorRate+fc.ResetRate+fc.HangRate > 1 {
+		return errors.New("rates must not add up to more than 1")
+	}
+	if fc.ErrorStatus == 0 {
+		fc.ErrorStatus = http.StatusInternalServerError
+	}
+	if fc.ErrorStatus < 100 || fc.ErrorStatus > 999 {
+		return fmt.Errorf("invalid errorStatus %d", fc.ErrorStatus)
+	}
+	if fc.HangMs == 0 {
+		fc.HangMs = 30000
+	}
+	if fc.HangMs < 0 {
+		return errors.New("hangMs must not be negative")
+	}
+	return nil
+}
+
+// inject rolls once against the configured rates and, on a hit, fails the
+// request. It reports whether it handled the request.
+func (fc *faultConfig) inject(w http.ResponseWriter) bool {
+	roll := mrand.Float64()
+	switch {
+	case roll < fc.ResetRate:
+		atomic.AddInt64(&totalFaults, 1)
+		dropConn(w, true)
+	case roll < fc.ResetRate+fc.HangRate:
+		atomic.AddInt64(&totalFaults, 1)
+		time.Sleep(time.Duration(fc.HangMs) * time.Millisecond)
+		dropConn(w, false)
+	case roll < fc.ResetRate+fc.HangRate+fc.ErrorRate:
+		atomic.AddInt64(&totalFaults, 1)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(fc.ErrorStatus)
+		fmt.Fprintf(w, `{"error":"injected fault","status":%d}`, fc.ErrorStatus)
+	default:
+		return false
+	}
+	return true
+}
+
+// dropConn closes the client connection without writing a response. With
+// reset set, SO_LINGER=0 makes the kernel send an RST instead of a FIN.
+// TLS and -conn-bandwidth wrappers are peeled off so the TCP socket itself
+// is closed, without a TLS close_notify. Connections that cannot be
+// hijacked (HTTP/2) have their stream aborted.
+func dropConn(w http.ResponseWriter, reset bool) {
+	conn, _, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		panic(http.ErrAbortHandler)
+	}
+	if tc, ok := conn.(interface{ NetConn() net.Conn }); ok {
+		conn = tc.NetConn()
+	}
+	if tc, ok := conn.(*throttledConn); ok {
+		conn = tc.Conn
+	}
+	if tcp, ok := conn.(*net.TCPConn); ok && reset {
+		tcp.SetLinger(0)
+	}
+	conn.Close()
+}
+
+// withFaults applies fc to requests that do not land on a config route
+// carrying its own faults, or on the /stats, /reset, /requests and /metrics
+// control endpoints. Requests it fails never reach a handler, so they are
+// counted in the /stats totals here, as routeHandler does for route faults.
+func withFaults(mux *http.ServeMux, fc *faultConfig, skip map[string]bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		if _, pattern := mux.Handler(r); !skip[pattern] && fc.inject(w) {
+			atomic.AddInt64(&totalRequests, 1)
+			atomic.AddInt64(&totalLatencyNs, time.Since(start).Nanoseconds())
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// selfSignedCert generates an ECDSA certificate valid for a day for
+// localhost, 127.0.0.1, ::1 and host.
+func selfSignedCert(host string) (tls.Certificate, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "vayu mock-server"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		if !ip.IsUnspecified() {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		}
+	} else if host != "" && host != "localhost" {
+		tmpl.DNSNames = append(tmpl.DNSNames, host)
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, certPEM, nil
+}
+
+// wsGUID is the fixed key suffix from RFC 6455 section 1.3.
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocket opcodes (RFC 6455 section 5.2).
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xA
+)
+
+// wsMaxPayload bounds a single inbound frame.
+const wsMaxPayload = 16 << 20
+
+// wsConn is a minimal server-side WebSocket connection: no extensions, no
+// subprotocols. Writes are serialized so the broadcaster and the read loop
+// can share a connection.
+type wsConn struct {
+	conn net.Conn
+	br   *bufio.Reader
+	mu   sync.Mutex
+}
+
+// wsUpgrade performs the opening handshake and takes over the connection.
+func wsUpgrade(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") ||
+		!strings.Contains(strings.ToLower(r.Header.Get("Connection")), "upgrade") {
+		return nil, errors.New("not a websocket upgrade request")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		return nil, errors.New("unsupported websocket version")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		return nil, errors.New("missing Sec-WebSocket-Key")
+	}
+
+	if r.ProtoMajor != 1 {
+		return nil, errors.New("websocket requires HTTP/1.1")
+	}
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return nil, err
+	}
+	// Hijacked connections keep the server's read/write deadlines.
+	conn.SetDeadline(time.Time{})
+
+	sum := sha1.Sum([]byte(key + wsGUID))
+	fmt.Fprintf(brw, "HTTP/1.1 101 Switching Protocols\r\n"+
+		"Upgrade: websocket\r\n"+
+		"Connection: Upgrade\r\n"+
+		"Sec-WebSocket-Accept: %s\r\n\r\n", base64.StdEncoding.EncodeToString(sum[:]))
+	if err := brw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{conn: conn, br: brw.Reader}, nil
+}
+
+// readFrame reads one frame and unmasks its payload. Client frames must be
+// masked (RFC 6455 section 5.1).
+func (c *wsConn) readFrame() (fin bool, op byte, payload []byte, err error) {
+	var hdr [2]byte
+	if _, err = io.ReadFull(c.br, hdr[:]); err != nil {
+		return
+	}
+	fin, op = hdr[0]&0x80 != 0, hdr[0]&0x0F
+	if hdr[1]&0x80 == 0 {
+		err = errors.New("unmasked client frame")
+		return
+	}
+
+	n := uint64(hdr[1] & 0x7F)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if n > wsMaxPayload {
+		err = fmt.Errorf("frame of %d bytes exceeds limit", n)
+		return
+	}
+
+	var mask [4]byte
+	if _, err = io.ReadFull(c.br, mask[:]); err != nil {
+		return
+	}
+	payload = make([]byte, n)
+	if _, err = io.ReadFull(c.br, payload); err != nil {
+		return
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return
+}
+
+// writeFrame writes one unmasked server frame.
+func (c *wsConn) writeFrame(fin bool, op byte, payload []byte) error {
+	hdr := make([]byte, 2, 10)
+	hdr[0] = op
+	if fin {
+		hdr[0] |= 0x80
+	}
+	switch n := len(payload); {
+	case n < 126:
+		hdr[1] = byte(n)
+	case n <= 0xFFFF:
+		hdr[1] = 126
+		hdr = binary.BigEndian.AppendUint16(hdr, uint16(n))
+	default:
+		hdr[1] = 127
+		hdr = binary.BigEndian.AppendUint64(hdr, uint64(n))
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := c.conn.Write(hdr); err != nil {
+		return err
+	}
+	_, err := c.conn.Write(payload)
+	return err
+}
+
+// serve runs the read loop, answering pings and the closing handshake.
+// Data frames are passed to onData; it returns when the peer closes or the
+// connection fails.
+func (c *wsConn) serve(onData func(fin bool, op byte, payload []byte) error) {
+	defer c.conn.Close()
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return
+		}
+		switch op {
+		case wsOpPing:
+			if c.writeFrame(true, wsOpPong, payload) != nil {
+				return
+			}
+		case wsOpPong:
+		case wsOpClose:
+			// Echo the status code back to complete the handshake.
+			if len(payload) > 2 {
+				payload = payload[:2]
+			}
+			c.writeFrame(true, wsOpClose, payload)
+			return
+		case wsOpText, wsOpBinary, wsOpContinuation:
+			if onData(fin, op, payload) != nil {
+				return
+			}
+		default:
+			c.writeFrame(true, wsOpClose, binary.BigEndian.AppendUint16(nil, 1002))
+			return
+		}
+	}
+}
+
+// wsHub fans broadcast messages out to every /ws/broadcast subscriber. Each
+// subscriber has a small queue; messages for a subscriber that cannot keep up
+// are dropped rather than stalling the others.
+type wsHub struct {
+	mu      sync.Mutex
+	subs    map[*wsConn]chan wsMessage
+	seq     int64
+	dropped int64
+}
+
+// wsMessage is a complete data message; op is wsOpText or wsOpBinary.
+type wsMessage struct {
+	op   byte
+	data []byte
+}
+
+func newWSHub() *wsHub {
+	return &wsHub{subs: make(map[*wsConn]chan wsMessage)}
+}
+
+func (h *wsHub) subscribe(c *wsConn) chan wsMessage {
+	ch := make(chan wsMessage, 256)
+	h.mu.Lock()
+	h.subs[c] = ch
+	h.mu.Unlock()
+	return ch
+}
+
+func (h *wsHub) unsubscribe(c *wsConn) {
+	h.mu.Lock()
+	delete(h.subs, c)
+	h.mu.Unlock()
+}
+
+func (h *wsHub) publish(msg wsMessage) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, ch := range h.subs {
+		select {
+		case ch <- msg:
+		default:
+			atomic.AddInt64(&h.dropped, 1)
+		}
+	}
+}
+
+// tick publishes a sequenced, timestamped message ratePerSec times a second
+// so clients can measure delivery latency and detect gaps.
+func (h *wsHub) tick(ratePerSec float64) {
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / ratePerSec))
+	defer ticker.Stop()
+	for range ticker.C {
+		seq := atomic.AddInt64(&h.seq, 1)
+		h.publish(wsMessage{wsOpText, fmt.Appendf(nil, `{"seq":%d,"ts":%d}`, seq, time.Now().UnixNano())})
+	}
+}
+
+// handleWSEcho echoes every data frame back unchanged, including
+// fragmentation.
+func handleWSEcho(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	atomic.AddInt64(&totalRequests, 1)
+	defer func() {
+		atomic.AddInt64(&totalLatencyNs, time.Since(start).Nanoseconds())
+	}()
+	c, err := wsUpgrade(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	atomic.AddInt64(&wsConnections, 1)
+	defer atomic.AddInt64(&wsConnections, -1)
+
+	c.serve(func(fin bool, op byte, payload []byte) error {
+		return c.writeFrame(fin, op, payload)
+	})
+}
+
+// handleWSBroadcast subscribes the client to the hub. Text or binary
+// messages the client sends are relayed to every subscriber with their
+// original opcode.
+func (h *wsHub) handleWSBroadcast(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	atomic.AddInt64(&totalRequests, 1)
+	defer func() {
+		atomic.AddInt64(&totalLatencyNs, time.Since(start).Nanoseconds())
+	}()
+	c, err := wsUpgrade(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	atomic.AddInt64(&wsConnections, 1)
+	defer atomic.AddInt64(&wsConnections, -1)
+
+	ch := h.subscribe(c)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case msg := <-ch:
+				if c.writeFrame(true, msg.op, msg.data) != nil {
+					c.conn.Close()
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	// Fragmented client messages are reassembled before relaying; the
+	// first fragment carries the opcode.
+	var (
+		partial []byte
+		msgOp   byte
+	)
+	c.serve(func(fin bool, op byte, payload []byte) error {
+		if op != wsOpContinuation {
+			msgOp = op
+		}
+		if len(partial)+len(payload) > wsMaxPayload {
+			c.writeFrame(true, wsOpClose, binary.BigEndian.AppendUint16(nil, 1009))
+			return errors.New("message too big")
+		}
+		partial = append(partial, payload...)
+		if fin {
+			h.publish(wsMessage{msgOp, partial})
+			partial = nil
+		}
+		return nil
+	})
+	h.unsubscribe(c)
+	close(done)
+}
+
+// gRPC status codes returned by the echo service (see grpc/codes).
+const (
+	grpcOK              = 0
+	grpcInvalidArgument = 3
+	grpcUnimplemented   = 12
+	grpcInternal        = 13
+)
+
+// grpcMaxMessage matches the default receive limit of gRPC clients.
+const grpcMaxMessage = 4 << 20
+
+// readGRPCMessage reads one length-prefixed message. It returns io.EOF when
+// the client half-closes between messages.
+func readGRPCMessage(r io.Reader) ([]byte, error) {
+	var hdr [5]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, errors.New("truncated message header")
+		}
+		return nil, err
+	}
+	if hdr[0] != 0 {
+		return nil, errors.New("compressed messages are not supported")
+	}
+	n := binary.BigEndian.Uint32(hdr[1:])
+	if n > grpcMaxMessage {
+		return nil, fmt.Errorf("message of %d bytes exceeds limit", n)
+	}
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, errors.New("truncated message")
+	}
+	return msg, nil
+}
+
+// writeGRPCMessage writes one uncompressed length-prefixed message and
+// flushes it so streaming clients see it immediately.
+func writeGRPCMessage(w http.ResponseWriter, msg []byte) error {
+	hdr := binary.BigEndian.AppendUint32([]byte{0}, uint32(len(msg)))
+	if _, err := w.Write(append(hdr, msg...)); err != nil {
+		return err
+	}
+	return http.NewResponseController(w).Flush()
+}
+
+// grpcFinish ends the call with the given status in the trailers.
+func grpcFinish(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(code))
+	if msg != "" {
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", url.PathEscape(msg))
+	}
+}
+
+// grpcCallOptions are per-call knobs read from request metadata so a load
+// test can vary them per request without restarting the mock:
+//
+//	x-mock-delay-ms     delay before the first response message
+//	x-mock-error-code   fail the call with this gRPC status code
+//	x-mock-error-rate   share of calls to fail (default 1 with an error code)
+//	x-mock-count        ServerStream messages to send (default 10)
+//	x-mock-interval-ms  pause between ServerStream messages
+type grpcCallOptions struct {
+	delay     time.Duration
+	errorCode int
+	errorRate float64
+	count     int
+	interval  time.Duration
+}
+
+func parseGRPCCallOptions(h http.Header) (grpcCallOptions, error) {
+	opts := grpcCallOptions{count: 10, errorRate: 1}
+	ints := []struct {
+		key string
+		dst *int
+	}{
+		{"X-Mock-Error-Code", &opts.errorCode},
+		{"X-Mock-Count", &opts.count},
+	}
+	for _, f := range ints {
+		if v := h.Get(f.key); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return opts, fmt.Errorf("invalid %s %q", strings.ToLower(f.key), v)
+			}
+			*f.dst = n
+		}
+	}
+	durations := []struct {
+		key string
+		dst *time.Duration
+	}{
+		{"X-Mock-Delay-Ms", &opts.delay},
+		{"X-Mock-Interval-Ms", &opts.interval},
+	}
+	for _, f := range durations {
+		if v := h.Get(f.key); v != "" {
+			ms, err := strconv.Atoi(v)
+			if err != nil || ms < 0 {
+				return opts, fmt.Errorf("invalid %s %q", strings.ToLower(f.key), v)
+			}
+			*f.dst = time.Duration(ms) * time.Millisecond
+		}
+	}
+	if v := h.Get("X-Mock-Error-Rate"); v != "" {
+		rate, err := strconv.ParseFloat(v, 64)
+		if err != nil || rate < 0 || rate > 1 {
+			return opts, fmt.Errorf("invalid x-mock-error-rate %q", v)
+		}
+		opts.errorRate = rate
+	}
+	if opts.errorCode > 16 {
+		return opts, fmt.Errorf("invalid x-mock-error-code %d", opts.errorCode)
+	}
+	return opts, nil
+}
+
+// handleGRPC serves the vayu.mock.v1.Echo service (see mock-echo.proto).
+// Messages are echoed as raw bytes, so any request message type works.
+func handleGRPC(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	atomic.AddInt64(&totalRequests, 1)
+	defer func() {
+		atomic.AddInt64(&totalLatencyNs, time.Since(start).Nanoseconds())
+	}()
+
+	if r.ProtoMajor != 2 {
+		http.Error(w, "gRPC requires HTTP/2 (start with -tls or -h2c)", http.StatusHTTPVersionNotSupported)
+		return
+	}
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "expected application/grpc", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	// Streams may outlive the server's HTTP read/write timeouts.
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Time{})
+	rc.SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "application/grpc")
+
+	opts, err := parseGRPCCallOptions(r.Header)
+	if err != nil {
+		grpcFinish(w, grpcInvalidArgument, err.Error())
+		return
+	}
+	if enc := r.Header.Get("Grpc-Encoding"); enc != "" && enc != "identity" {
+		grpcFinish(w, grpcUnimplemented, "compression not supported")
+		return
+	}
+
+	time.Sleep(opts.delay)
+	if opts.errorCode != grpcOK && mrand.Float64() < opts.errorRate {
+		atomic.AddInt64(&totalFaults, 1)
+		grpcFinish(w, opts.errorCode, "injected fault")
+		return
+	}
+
+	switch r.PathValue("method") {
+	case "Unary":
+		msg, err := readGRPCMessage(r.Body)
+		if err != nil {
+			grpcFinish(w, grpcInvalidArgument, "expected one request message")
+			return
+		}
+		writeGRPCMessage(w, msg)
+
+	case "ServerStream":
+		msg, err := readGRPCMessage(r.Body)
+		if err != nil {
+			grpcFinish(w, grpcInvalidArgument, "expected one request message")
+			return
+		}
+		for i := 0; i < opts.count; i++ {
+			if i > 0 {
+				time.Sleep(opts.interval)
+			}
+			if writeGRPCMessage(w, msg) != nil {
+				return
+			}
+		}
+
+	case "ClientStream":
+		// Replies with the last message received.
+		var last []byte
+		for {
+			msg, err := readGRPCMessage(r.Body)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				grpcFinish(w, grpcInvalidArgument, err.Error())
+				return
+			}
+			last = msg
+		}
+		writeGRPCMessage(w, last)
+
+	case "BidiStream":
+		// Send headers first so the client can start reading.
+		w.WriteHeader(http.StatusOK)
+		rc.Flush()
+		for {
+			msg, err := readGRPCMessage(r.Body)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				grpcFinish(w, grpcInternal, err.Error())
+				return
+			}
+			if writeGRPCMessage(w, msg) != nil {
+				return
+			}
+		}
+
+	default:
+		grpcFinish(w, grpcUnimplemented, "unknown method "+r.PathValue("method"))
+		return
+	}
+	grpcFinish(w, grpcOK, "")
+}
+
+// crudStore backs /api/{resource}: one collection of JSON objects per
+// resource name, created on first write. Auto-assigned IDs are sequential
+// per collection; every id is stored and returned as a string.
+type crudStore struct {
+	mu          sync.Mutex
+	collections map[string]*crudCollection
+}
+
+type crudCollection struct {
+	nextID int64
+	order  []string // insertion order, for stable listings
+	items  map[string]map[string]any
+}
+
+// maxCRUDBody bounds request bodies accepted by the CRUD store.
+const maxCRUDBody = 1 << 20
+
+func newCRUDStore() *crudStore {
+	return &crudStore{collections: make(map[string]*crudCollection)}
+}
+
+func (s *crudStore) collection(name string) *crudCollection {
+	c := s.collections[name]
+	if c == nil {
+		c = &crudCollection{items: make(map[string]map[string]any)}
+		s.collections[name] = c
+	}
+	return c
+}
+
+// get returns an item without creating the collection.
+func (s *crudStore) get(name, id string) (map[string]any, bool) {
+	if c := s.collections[name]; c != nil {
+		item, ok := c.items[id]
+		return item, ok
+	}
+	return nil, false
+}
+
+func (c *crudCollection) put(id string, item map[string]any) {
+	if _, ok := c.items[id]; !ok {
+		c.order = append(c.order, id)
+	}
+	c.items[id] = item
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// crudID validates an "id" from a POST body. IDs are stored as strings,
+// the same as the {id} path segment, so POST and PUT agree on the type.
+func crudID(v any) (string, error) {
+	var id string
+	switch v := v.(type) {
+	case string:
+		id = v
+	case json.Number:
+		id = v.String()
+	default:
+		return "", errors.New("id must be a string or a number")
+	}
+	if id == "" || strings.Contains(id, "/") {
+		return "", fmt.Errorf("invalid id %q", id)
+	}
+	return id, nil
+}
+
+// readJSONObject decodes a request body that must be a single JSON object.
+func readJSONObject(w http.ResponseWriter, r *http.Request) (map[string]any, error) {
+	var item map[string]any
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxCRUDBody))
+	dec.UseNumber()
+	if err := dec.Decode(&item); err != nil || item == nil {
+		return nil, errors.New("body must be a JSON object")
+	}
+	return item, nil
+}
+
+// handleCollection serves /api/{resource}: list, create and clear.
+func (s *crudStore) handleCollection(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	atomic.AddInt64(&totalRequests, 1)
+	defer func() {
+		atomic.AddInt64(&totalLatencyNs, time.Since(start).Nanoseconds())
+	}()
+
+	name := r.PathValue("resource")
+	switch r.Method {
+	case http.MethodGet:
+		s.mu.Lock()
+		list := []map[string]any{}
+		if c := s.collections[name]; c != nil {
+			for _, id := range c.order {
+				list = append(list, c.items[id])
+			}
+		}
+		s.mu.Unlock()
+		writeJSON(w, http.StatusOK, list)
+
+	case http.MethodPost:
+		item, err := readJSONObject(w, r)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		var id string
+		if v, ok := item["id"]; ok {
+			if id, err = crudID(v); err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+				return
+			}
+		}
+		s.mu.Lock()
+		c := s.collection(name)
+		if id != "" {
+			if _, exists := c.items[id]; exists {
+				s.mu.Unlock()
+				writeJSON(w, http.StatusConflict, map[string]string{"error": "id " + id + " already exists"})
+				return
+			}
+		} else {
+			// Skip IDs taken by explicit POSTs or PUTs.
+			for id == "" || c.items[id] != nil {
+				c.nextID++
+				id = strconv.FormatInt(c.nextID, 10)
+			}
+		}
+		item["id"] = id
+		c.put(id, item)
+		s.mu.Unlock()
+		w.Header().Set("Location", "/api/"+name+"/"+url.PathEscape(id))
+		writeJSON(w, http.StatusCreated, item)
+
+	case http.MethodDelete:
+		s.mu.Lock()
+		delete(s.collections, name)
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+	}
+}
+
+// handleItem serves /api/{resource}/{id}: read, replace, merge and delete.
+// PUT creates the item if it does not exist.
+func (s *crudStore) handleItem(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	atomic.AddInt64(&totalRequests, 1)
+	defer func() {
+		atomic.AddInt64(&totalLatencyNs, time.Since(start).Nanoseconds())
+	}()
+
+	name, id := r.PathValue("resource"), r.PathValue("id")
+	notFound := map[string]string{"error": name + " " + id + " not found"}
+
+	switch r.Method {
+	case http.MethodGet:
+		s.mu.Lock()
+		item, ok := s.get(name, id)
+		s.mu.Unlock()
+		if !ok {
+			writeJSON(w, http.StatusNotFound, notFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, item)
+
+	case http.MethodPut, http.MethodPatch:
+		body, err := readJSONObject(w, r)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		s.mu.Lock()
+		c := s.collection(name)
+		existing, ok := c.items[id]
+		if r.Method == http.MethodPatch {
+			if !ok {
+				s.mu.Unlock()
+				writeJSON(w, http.StatusNotFound, notFound)
+				return
+			}
+			merged := make(map[string]any, len(existing)+len(body))
+			for k, v := range existing {
+				merged[k] = v
+			}
+			for k, v := range body {
+				merged[k] = v
+			}
+			body = merged
+		}
+		if ok {
+			body["id"] = existing["id"]
+		} else {
+			body["id"] = id
+		}
+		c.put(id, body)
+		s.mu.Unlock()
+
+		status := http.StatusOK
+		if !ok {
+			status = http.StatusCreated
+		}
+		writeJSON(w, status, body)
+
+	case http.MethodDelete:
+		s.mu.Lock()
+		_, ok := s.get(name, id)
+		if ok {
+			c := s.collections[name]
+			delete(c.items, id)
+			c.order = slices.DeleteFunc(c.order, func(o string) bool { return o == id })
+		}
+		s.mu.Unlock()
+		if !ok {
+			writeJSON(w, http.StatusNotFound, notFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.Header().Set("Allow", "GET, PUT, PATCH, DELETE")
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+	}
+}
+
+// recordedRequest is one entry in the -record ring buffer.
+type recordedRequest struct {
+	Seq           int64       `json:"seq"`
+	Time          time.Time   `json:"time"`
+	RemoteAddr    string      `json:"remote_addr"`
+	Proto         string      `json:"proto"`
+	Method        string      `json:"method"`
+	Path          string      `json:"path"`
+	Query         string      `json:"query,omitempty"`
+	Headers       http.Header `json:"headers"`
+	Body          string      `json:"body,omitempty"`
+	BodyBase64    string      `json:"body_base64,omitempty"`
+	BodyTruncated bool        `json:"body_truncated,omitempty"`
+}
+
+// requestRecorder keeps the last len(ring) requests. Only the first
+// maxBody bytes of each body are kept; the handler still sees all of it.
+type requestRecorder struct {
+	mu      sync.Mutex
+	ring    []recordedRequest
+	next    int
+	full    bool
+	seq     int64
+	maxBody int
+}
+
+func newRequestRecorder(capacity, maxBody int) *requestRecorder {
+	return &requestRecorder{ring: make([]recordedRequest, capacity), maxBody: maxBody}
+}
+
+func (rr *requestRecorder) add(rec recordedRequest) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	rr.seq++
+	rec.Seq = rr.seq
+	rr.ring[rr.next] = rec
+	rr.next = (rr.next + 1) % len(rr.ring)
+	if rr.next == 0 {
+		rr.full = true
+	}
+}
+
+// snapshot returns the recorded requests, oldest first.
+func (rr *requestRecorder) snapshot() []recordedRequest {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	if !rr.full {
+		return slices.Clone(rr.ring[:rr.next])
+	}
+	return append(slices.Clone(rr.ring[rr.next:]), rr.ring[:rr.next]...)
+}
+
+func (rr *requestRecorder) reset() {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	clear(rr.ring)
+	rr.next, rr.full = 0, false
+}
+
+// middleware records each request before passing it on. Bodies of gRPC and
+// upgrade requests are not captured, since reading ahead would stall a
+// stream; /requests, /stats and /metrics are not recorded at all.
+func (rr *requestRecorder) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/requests" || r.URL.Path == "/stats" || r.URL.Path == "/metrics" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rec := recordedRequest{
+			Time:       time.Now(),
+			RemoteAddr: r.RemoteAddr,
+			Proto:      r.Proto,
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			Query:      r.URL.RawQuery,
+			Headers:    r.Header.Clone(),
+		}
+		streaming := r.Header.Get("Upgrade") != "" ||
+			strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc")
+		if r.Body != nil && r.Body != http.NoBody && !streaming {
+			// Read one byte past the cap to tell a full body from a cut one,
+			// then hand the handler the prefix followed by the rest.
+			prefix, _ := io.ReadAll(io.LimitReader(r.Body, int64(rr.maxBody)+1))
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(prefix), r.Body), r.Body}
+
+			if len(prefix) > rr.maxBody {
+				prefix, rec.BodyTruncated = prefix[:rr.maxBody], true
+			}
+			if utf8.Valid(prefix) {
+				rec.Body = string(prefix)
+			} else {
+				rec.BodyBase64 = base64.StdEncoding.EncodeToString(prefix)
+			}
+		}
+		rr.add(rec)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleRequests lists (GET) or clears (DELETE) recorded requests. GET
+// filters on ?method= and ?path= (a prefix) and keeps the newest ?limit.
+func (rr *requestRecorder) handleRequests(w http.ResponseWriter, r *http.Request) {
+	if rr == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "request recording is off; start with -record N"})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		q := r.URL.Query()
+		limit := 0
+		if v := q.Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid limit"})
+				return
+			}
+			limit = n
+		}
+
+		list := rr.snapshot()
+		method, path := strings.ToUpper(q.Get("method")), q.Get("path")
+		list = slices.DeleteFunc(list, func(rec recordedRequest) bool {
+			return (method != "" && rec.Method != method) || !strings.HasPrefix(rec.Path, path)
+		})
+		if limit > 0 && len(list) > limit {
+			list = list[len(list)-limit:]
+		}
+
+		rr.mu.Lock()
+		total := rr.seq
+		rr.mu.Unlock()
+		writeJSON(w, http.StatusOK, map[string]any{
+			"capacity": len(rr.ring),
+			"total":    total,
+			"requests": list,
+		})
+
+	case http.MethodDelete:
+		rr.reset()
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.Header().Set("Allow", "GET, DELETE")
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+	}
+}
+
+// latencyBuckets are the /metrics histogram upper bounds in seconds. They
+// start well below a millisecond since most mock handlers answer in
+// microseconds.
+var latencyBuckets = []float64{
+	0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025,
+	0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10,
+}
+
+// pathMetrics holds the /metrics series for one route pattern. Counters are
+// updated with atomics.
+type pathMetrics struct {
+	codes   [1000]int64 // requests by status code; config allows 100-999
+	aborted int64       // handler panicked or dropped the connection
+	buckets []int64     // per-bucket (non-cumulative) counts
+	count   int64
+	sumNs   int64
+}
+
+// metricsRegistry backs /metrics. Series are keyed by the ServeMux pattern
+// that served the request (e.g. "/slow/"), not the raw path, so label
+// cardinality stays bounded.
+//
+// The pattern map is copy-on-write: requests look their series up through
+// an atomic pointer without locking, and mu only serializes the rare
+// writers (a pattern's first request, /reset). This file backs published
+// benchmark numbers, so the per-request path must not share a lock.
+type metricsRegistry struct {
+	mu       sync.Mutex
+	paths    atomic.Pointer[map[string]*pathMetrics]
+	inFlight int64
+}
+
+func newMetricsRegistry() *metricsRegistry {
+	m := &metricsRegistry{}
+	m.paths.Store(&map[string]*pathMetrics{})
+	return m
+}
+
+// snapshot returns the current series. The map must not be modified.
+func (m *metricsRegistry) snapshot() map[string]*pathMetrics {
+	return *m.paths.Load()
+}
+
+func (m *metricsRegistry) path(pattern string) *pathMetrics {
+	if pm := m.snapshot()[pattern]; pm != nil {
+		return pm
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	old := m.snapshot()
+	if pm := old[pattern]; pm != nil {
+		return pm
+	}
+	pm := &pathMetrics{buckets: make([]int64, len(latencyBuckets))}
+	paths := make(map[string]*pathMetrics, len(old)+1)
+	maps.Copy(paths, old)
+	paths[pattern] = pm
+	m.paths.Store(&paths)
+	return pm
+}
+
+// observe records one finished request. A status of 0 means no response
+// was written (aborted or hijacked).
+func (pm *pathMetrics) observe(status int, d time.Duration) {
+	if status > 0 && status < len(pm.codes) {
+		atomic.AddInt64(&pm.codes[status], 1)
+	} else {
+		atomic.AddInt64(&pm.aborted, 1)
+	}
+	atomic.AddInt64(&pm.count, 1)
+	atomic.AddInt64(&pm.sumNs, d.Nanoseconds())
+	if i, _ := slices.BinarySearch(latencyBuckets, d.Seconds()); i < len(pm.buckets) {
+		atomic.AddInt64(&pm.buckets[i], 1)
+	}
+}
+
+// statusRecorder captures the status code a handler writes. Flushing,
+// deadlines and the like reach the underlying writer through Unwrap.
+type statusRecorder struct {
+	http.ResponseWriter
+	status   int
+	upgrade  bool // request asked for a protocol upgrade
+	hijacked bool
+}
+
+func (sr *statusRecorder) WriteHeader(code int) {
+	if sr.status == 0 && code >= 200 {
+		sr.status = code
+	}
+	sr.ResponseWriter.WriteHeader(code)
+}
+
+func (sr *statusRecorder) Write(b []byte) (int, error) {
+	if sr.status == 0 {
+		sr.status = http.StatusOK
+	}
+	return sr.ResponseWriter.Write(b)
+}
+
+func (sr *statusRecorder) Unwrap() http.ResponseWriter {
+	return sr.ResponseWriter
+}
+
+// Hijack counts a hijacked upgrade (WebSocket) as 101. Other hijacks are
+// injected resets and hangs, which stay at 0 and count as aborted.
+func (sr *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(sr.ResponseWriter).Hijack()
+	if err == nil {
+		sr.hijacked = true
+		if sr.upgrade && sr.status == 0 {
+			sr.status = http.StatusSwitchingProtocols
+		}
+	}
+	return conn, brw, err
+}
 
-package main
+// middleware measures every request. Requests failed by fault injection
+// never reach the mux, so their pattern is looked up separately.
+func (m *metricsRegistry) middleware(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		atomic.AddInt64(&m.inFlight, 1)
+		sr := &statusRecorder{ResponseWriter: w, upgrade: r.Header.Get("Upgrade") != ""}
 
-import (
-	"encoding/json"
-	"flag"
-	"fmt"
-	"log"
-	"net/http"
-	"os"
-	"runtime"
-	"strconv"
-	"strings"
-	"sync/atomic"
-	"time"
-)
+		defer func() {
+			atomic.AddInt64(&m.inFlight, -1)
+			pattern := r.Pattern
+			if pattern == "" {
+				_, pattern = mux.Handler(r)
+			}
+			status := sr.status
+			p := recover()
+			if p != nil {
+				status = 0
+			} else if status == 0 && !sr.hijacked {
+				status = http.StatusOK
+			}
+			m.path(pattern).observe(status, time.Since(start))
+			if p != nil {
+				panic(p)
+			}
+		}()
+
+		next.ServeHTTP(sr, r)
+	})
+}
+
+// reset drops every series, for /reset.
+func (m *metricsRegistry) reset() {
+	m.mu.Lock()
+	m.paths.Store(&map[string]*pathMetrics{})
+	m.mu.Unlock()
+}
+
+// pathStats is the per-path entry in /stats.
+type pathStats struct {
+	Requests       int64            `json:"requests"`
+	RequestsPerSec float64          `json:"requests_per_sec"`
+	AvgLatencyUs   float64          `json:"avg_latency_us"`
+	StatusCodes    map[string]int64 `json:"status_codes"`
+}
+
+// stats summarizes each route pattern over uptime seconds.
+func (m *metricsRegistry) stats(uptime float64) map[string]pathStats {
+	paths := m.snapshot()
+	out := make(map[string]pathStats, len(paths))
+	for pattern, pm := range paths {
+		ps := pathStats{
+			Requests:    atomic.LoadInt64(&pm.count),
+			StatusCodes: make(map[string]int64),
+		}
+		if ps.Requests > 0 {
+			ps.AvgLatencyUs = float64(atomic.LoadInt64(&pm.sumNs)) / float64(ps.Requests) / 1000.0
+		}
+		if uptime > 0 {
+			ps.RequestsPerSec = float64(ps.Requests) / uptime
+		}
+		for code := range pm.codes {
+			if n := atomic.LoadInt64(&pm.codes[code]); n > 0 {
+				ps.StatusCodes[strconv.Itoa(code)] = n
+			}
+		}
+		if n := atomic.LoadInt64(&pm.aborted); n > 0 {
+			ps.StatusCodes["aborted"] = n
+		}
+		out[pattern] = ps
+	}
+	return out
+}
+
+// sinceStart is the time since startup or the last /reset.
+func sinceStart() time.Duration {
+	return time.Since(*startTime.Load())
+}
+
+func resetStartTime() {
+	now := time.Now()
+	startTime.Store(&now)
+}
+
+// promEscaper escapes Prometheus label values.
+var promEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// handleMetrics writes the registry and the server gauges in the
+// Prometheus text exposition format.
+func (m *metricsRegistry) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	// Work from one snapshot: looking series up again with m.path after a
+	// concurrent /reset would recreate them empty.
+	series := m.snapshot()
+	patterns := slices.Sorted(maps.Keys(series))
+
+	var b strings.Builder
+	b.WriteString("# HELP mock_http_requests_total Requests served, by route pattern and status code.\n")
+	b.WriteString("# TYPE mock_http_requests_total counter\n")
+	for _, p := range patterns {
+		pm, label := series[p], promEscaper.Replace(p)
+		for code := range pm.codes {
+			if n := atomic.LoadInt64(&pm.codes[code]); n > 0 {
+				fmt.Fprintf(&b, "mock_http_requests_total{path=\"%s\",code=\"%d\"} %d\n", label, code, n)
+			}
+		}
+		if n := atomic.LoadInt64(&pm.aborted); n > 0 {
+			fmt.Fprintf(&b, "mock_http_requests_total{path=\"%s\",code=\"aborted\"} %d\n", label, n)
+		}
+	}
+
+	b.WriteString("# HELP mock_http_request_duration_seconds Handler latency, by route pattern.\n")
+	b.WriteString("# TYPE mock_http_request_duration_seconds histogram\n")
+	for _, p := range patterns {
+		pm, label := series[p], promEscaper.Replace(p)
+		var cumulative int64
+		for i, le := range latencyBuckets {
+			cumulative += atomic.LoadInt64(&pm.buckets[i])
+			fmt.Fprintf(&b, "mock_http_request_duration_seconds_bucket{path=\"%s\",le=\"%g\"} %d\n", label, le, cumulative)
+		}
+		count := atomic.LoadInt64(&pm.count)
+		fmt.Fprintf(&b, "mock_http_request_duration_seconds_bucket{path=\"%s\",le=\"+Inf\"} %d\n", label, count)
+		fmt.Fprintf(&b, "mock_http_request_duration_seconds_sum{path=\"%s\"} %g\n", label,
+			time.Duration(atomic.LoadInt64(&pm.sumNs)).Seconds())
+		fmt.Fprintf(&b, "mock_http_request_duration_seconds_count{path=\"%s\"} %d\n", label, count)
+	}
+
+	gauges := []struct {
+		name, help, kind string
+		value            float64
+	}{
+		{"mock_http_requests_in_flight", "Requests currently being served.", "gauge", float64(atomic.LoadInt64(&m.inFlight))},
+		{"mock_websocket_connections", "Open WebSocket connections.", "gauge", float64(atomic.LoadInt64(&wsConnections))},
+		{"mock_sse_connections", "Open server-sent event streams.", "gauge", float64(atomic.LoadInt64(&sseConnections))},
+		{"mock_injected_faults_total", "Requests failed by fault injection.", "counter", float64(atomic.LoadInt64(&totalFaults))},
+		{"mock_overload_rejections_total", "Requests answered 503 by the -max-concurrent limit.", "counter", float64(atomic.LoadInt64(&totalOverloaded))},
+		{"mock_rate_limited_total", "Requests answered 429 by -rate-limit.", "counter", float64(atomic.LoadInt64(&totalLimited))},
+		{"mock_goroutines", "Goroutines in the mock server.", "gauge", float64(runtime.NumGoroutine())},
+		{"mock_uptime_seconds", "Seconds since start or the last /reset.", "gauge", sinceStart().Seconds()},
+	}
+	for _, g := range gauges {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n%s %g\n", g.name, g.help, g.name, g.kind, g.name, g.value)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	io.WriteString(w, b.String())
+}
+
+// concurrencyLimiter bounds requests in progress. Control endpoints are
+// exempt so an overloaded server can still be observed and reset.
+type concurrencyLimiter struct {
+	slots   chan struct{}
+	queue   bool
+	timeout time.Duration
+}
+
+func (cl *concurrencyLimiter) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/stats", "/metrics", "/requests", "/reset":
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !cl.acquire(r) {
+			atomic.AddInt64(&totalOverloaded, 1)
+			w.Header().Set("Retry-After", "1")
+			writeJSON(w, http.StatusServiceUnavailable, map[string]any{
+				"error":          "overloaded",
+				"max_concurrent": cap(cl.slots),
+			})
+			return
+		}
+		defer func() { <-cl.slots }()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// acquire takes a slot, waiting up to the queue timeout in queue mode. It
+// gives up early if the client goes away.
+func (cl *concurrencyLimiter) acquire(r *http.Request) bool {
+	select {
+	case cl.slots <- struct{}{}:
+		return true
+	default:
+		if !cl.queue {
+			return false
+		}
+	}
+
+	timer := time.NewTimer(cl.timeout)
+	defer timer.Stop()
+	select {
+	case cl.slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-r.Context().Done():
+		return false
+	}
+}
+
+// tokenBucket refills at rate tokens/sec up to burst.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter keeps one token bucket per key. Keys are the client IP, the
+// ServeMux pattern, or both, so /slow/1 and /slow/2 share a route bucket.
+type rateLimiter struct {
+	mux   *http.ServeMux
+	rate  float64
+	burst float64
+	byIP  bool
+	byRt  bool
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+func newRateLimiter(mux *http.ServeMux, rate float64, burst int, key string) *rateLimiter {
+	rl := &rateLimiter{
+		mux:     mux,
+		rate:    rate,
+		burst:   float64(burst),
+		byIP:    key == "ip" || key == "ip+route",
+		byRt:    key == "route" || key == "ip+route",
+		buckets: make(map[string]*tokenBucket),
+	}
+	go rl.evictIdle()
+	return rl
+}
+
+// take spends a token for key. When none is left it returns how long until
+// one is.
+func (rl *rateLimiter) take(key string) (ok bool, remaining int, wait time.Duration) {
+	now := time.Now()
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	b := rl.buckets[key]
+	if b == nil {
+		b = &tokenBucket{tokens: rl.burst, last: now}
+		rl.buckets[key] = b
+	}
+	b.tokens = min(rl.burst, b.tokens+now.Sub(b.last).Seconds()*rl.rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		return false, 0, time.Duration((1 - b.tokens) / rl.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, int(b.tokens), 0
+}
+
+// evictIdle drops buckets that have refilled completely; a fresh bucket
+// for that key would be identical.
+func (rl *rateLimiter) evictIdle() {
+	full := time.Duration(rl.burst / rl.rate * float64(time.Second))
+	for range time.Tick(time.Minute) {
+		now := time.Now()
+		rl.mu.Lock()
+		for key, b := range rl.buckets {
+			if now.Sub(b.last) > full {
+				delete(rl.buckets, key)
+			}
+		}
+		rl.mu.Unlock()
+	}
+}
+
+func (rl *rateLimiter) key(r *http.Request) string {
+	var parts []string
+	if rl.byIP {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		parts = append(parts, host)
+	}
+	if rl.byRt {
+		_, pattern := rl.mux.Handler(r)
+		parts = append(parts, pattern)
+	}
+	return strings.Join(parts, " ")
+}
+
+// middleware answers 429 once a key's bucket is empty. Control endpoints
+// are exempt.
+func (rl *rateLimiter) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/stats", "/metrics", "/requests", "/reset":
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ok, remaining, wait := rl.take(rl.key(r))
+		w.Header().Set("X-RateLimit-Limit", strconv.FormatFloat(rl.rate, 'f', -1, 64))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		if !ok {
+			atomic.AddInt64(&totalLimited, 1)
+			// Retry-After is whole seconds; round up so an obedient client
+			// never comes back early.
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeJSON(w, http.StatusTooManyRequests, map[string]any{
+				"error":          "rate limited",
+				"retry_after_ms": wait.Milliseconds(),
+			})
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// maxBytesResponse caps /bytes/N.
+const maxBytesResponse = 1 << 30
 
 var (
-	totalRequests   int64
-	totalLatencyNs  int64
-	startTime       time.Time
-	requestsPerPath = make(map[string]*int64)
+	// randomBlock is repeated to build random /bytes bodies. At 64 KiB it is
+	// twice the deflate window, so repetition does not make it compressible.
+	randomBlock = make([]byte, 64<<10)
+	// patternBlock is a repeating ASCII pattern that gzip shrinks ~100x.
+	patternBlock = []byte(strings.Repeat("vayu-mock-0123456789abcdefghijklmnopqrstuvwxyz\n", 1400))
 )
 
+func init() {
+	rand.Read(randomBlock)
+}
+
+// handleBytes writes an N-byte body of random (incompressible) or repeating
+// (compressible) content. With ?gzip=1 and a client that accepts gzip, the
+// body is gzip-encoded; N is always the uncompressed size.
+func handleBytes(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	atomic.AddInt64(&totalRequests, 1)
+	defer func() {
+		atomic.AddInt64(&totalLatencyNs, time.Since(start).Nanoseconds())
+	}()
+
+	n, err := strconv.ParseInt(r.PathValue("n"), 10, 64)
+	if err != nil || n < 0 || n > maxBytesResponse {
+		http.Error(w, fmt.Sprintf("size must be between 0 and %d", maxBytesResponse), http.StatusBadRequest)
+		return
+	}
+
+	block := randomBlock
+	switch fill := r.URL.Query().Get("fill"); fill {
+	case "", "random":
+	case "pattern":
+		block = patternBlock
+	default:
+		http.Error(w, "fill must be random or pattern", http.StatusBadRequest)
+		return
+	}
+
+	// Large bodies on slow links can outlast the server's write timeout.
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	var out io.Writer = w
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if r.URL.Query().Get("gzip") == "1" && strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Set("Vary", "Accept-Encoding")
+		gz := gzip.NewWriter(w)
+		defer gz.Close()
+		out = gz
+	} else {
+		w.Header().Set("Content-Length", strconv.FormatInt(n, 10))
+	}
+
+	for n > 0 {
+		chunk := block[:min(n, int64(len(block)))]
+		if _, err := out.Write(chunk); err != nil {
+			return
+		}
+		n -= int64(len(chunk))
+	}
+}
+
+// handleSSE streams a sequenced, timestamped event every intervalMs until
+// the client disconnects or ?count events have been sent. A reconnecting
+// client's Last-Event-ID resumes the sequence.
+func handleSSE(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	atomic.AddInt64(&totalRequests, 1)
+	defer func() {
+		atomic.AddInt64(&totalLatencyNs, time.Since(start).Nanoseconds())
+	}()
+
+	intervalMs, err := strconv.Atoi(r.PathValue("intervalMs"))
+	if err != nil || intervalMs <= 0 {
+		http.Error(w, "interval must be a positive number of milliseconds", http.StatusBadRequest)
+		return
+	}
+	count := 0 // unlimited
+	if v := r.URL.Query().Get("count"); v != "" {
+		if count, err = strconv.Atoi(v); err != nil || count < 0 {
+			http.Error(w, "invalid count", http.StatusBadRequest)
+			return
+		}
+	}
+	seq := 0
+	if v := r.Header.Get("Last-Event-ID"); v != "" {
+		seq, _ = strconv.Atoi(v)
+	}
+
+	// The stream outlives the server's write timeout.
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+
+	atomic.AddInt64(&sseConnections, 1)
+	defer atomic.AddInt64(&sseConnections, -1)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", max(intervalMs, 1000))
+	if rc.Flush() != nil {
+		return
+	}
+
+	ticker := time.NewTicker(time.Duration(intervalMs) * time.Millisecond)
+	defer ticker.Stop()
+	for sent := 0; count == 0 || sent < count; sent++ {
+		select {
+		case <-r.Context().Done():
+			return
+		case now := <-ticker.C:
+			seq++
+			fmt.Fprintf(w, "id: %d\nevent: tick\ndata: {\"seq\":%d,\"ts\":%d}\n\n", seq, seq, now.UnixNano())
+			if rc.Flush() != nil {
+				return
+			}
+		}
+	}
+}
+
+// parseByteSize parses sizes such as "1500", "64KB", "1MB" or "2MiB".
+// KB/MB/GB are decimal; KiB/MiB/GiB are binary.
+func parseByteSize(v string) (int64, error) {
+	units := []struct {
+		suffix string
+		mult   int64
+	}{
+		{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30},
+		{"KB", 1000}, {"MB", 1000 * 1000}, {"GB", 1000 * 1000 * 1000},
+		{"B", 1},
+	}
+	num, mult := strings.TrimSpace(v), int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(num, u.suffix) {
+			num, mult = strings.TrimSpace(strings.TrimSuffix(num, u.suffix)), u.mult
+			break
+		}
+	}
+	f, err := strconv.ParseFloat(num, 64)
+	if err != nil || f < 0 {
+		return 0, fmt.Errorf("invalid size %q", v)
+	}
+	return int64(f * float64(mult)), nil
+}
+
+// throttledListener caps the write throughput of every accepted connection.
+type throttledListener struct {
+	net.Listener
+	bytesPerSec int64
+}
+
+func (l throttledListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &throttledConn{Conn: conn, bytesPerSec: l.bytesPerSec}, nil
+}
+
+// throttledConn paces writes so the connection averages bytesPerSec. Writes
+// are split into ~10ms slices so a large response trickles out smoothly
+// instead of in one burst per second.
+type throttledConn struct {
+	net.Conn
+	bytesPerSec int64
+	mu          sync.Mutex
+	next        time.Time
+}
+
+func (c *throttledConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	slice := max(int(c.bytesPerSec/100), 1)
+	written := 0
+	for written < len(p) {
+		chunk := p[written:min(written+slice, len(p))]
+		if wait := time.Until(c.next); wait > 0 {
+			time.Sleep(wait)
+		}
+		n, err := c.Conn.Write(chunk)
+		written += n
+		cost := time.Duration(float64(n) / float64(c.bytesPerSec) * float64(time.Second))
+		if now := time.Now(); c.next.Before(now) {
+			c.next = now
+		}
+		c.next = c.next.Add(cost)
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// loadConfig reads and validates a route config file.
+func loadConfig(path string) (*mockConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg mockConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	for i := range cfg.Routes {
+		rc := &cfg.Routes[i]
+		if !strings.HasPrefix(rc.Path, "/") {
+			return nil, fmt.Errorf("route %d: path %q must start with /", i, rc.Path)
+		}
+		if rc.Status == 0 {
+			rc.Status = http.StatusOK
+		}
+		if rc.Status < 100 || rc.Status > 999 {
+			return nil, fmt.Errorf("route %d: invalid status %d", i, rc.Status)
+		}
+		if rc.DelayMs < 0 {
+			return nil, fmt.Errorf("route %d: delayMs must not be negative", i)
+		}
+		if rc.Latency != nil {
+			if rc.DelayMs > 0 {
+				return nil, fmt.Errorf("route %d: set either delayMs or latency, not both", i)
+			}
+			if err := rc.Latency.validate(); err != nil {
+				return nil, fmt.Errorf("route %d: latency: %w", i, err)
+			}
+		}
+		if rc.Faults != nil {
+			if err := rc.Faults.validate(); err != nil {
+				return nil, fmt.Errorf("route %d: faults: %w", i, err)
+			}
+		}
+		if rc.Template {
+			if err := rc.compileTemplates(); err != nil {
+				return nil, fmt.Errorf("route %d: template: %w", i, err)
+			}
+		}
+		rc.Method = strings.ToUpper(rc.Method)
+	}
+	if cfg.Faults != nil {
+		if err := cfg.Faults.validate(); err != nil {
+			return nil, fmt.Errorf("faults: %w", err)
+		}
+	}
+
+	return &cfg, nil
+}
+
+// pattern returns the ServeMux pattern for the route, e.g. "GET /users/{id}".
+func (rc routeConfig) pattern() string {
+	if rc.Method == "" {
+		return rc.Path
+	}
+	return rc.Method + " " + rc.Path
+}
+
+// routeHandler serves a configured route's canned response.
+func routeHandler(rc routeConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		atomic.AddInt64(&totalRequests, 1)
+		defer func() {
+			atomic.AddInt64(&totalLatencyNs, time.Since(start).Nanoseconds())
+		}()
+
+		if rc.Faults != nil && rc.Faults.inject(w) {
+			return
+		}
+
+		if rc.Latency != nil {
+			time.Sleep(rc.Latency.sample())
+		} else if rc.DelayMs > 0 {
+			time.Sleep(time.Duration(rc.DelayMs) * time.Millisecond)
+		}
+
+		if rc.Template {
+			renderTemplateRoute(w, r, &rc)
+		} else {
+			for k, v := range rc.Headers {
+				w.Header().Set(k, v)
+			}
+			w.WriteHeader(rc.Status)
+			w.Write([]byte(rc.Body))
+		}
+	}
+}
+
+// renderTemplateRoute executes the route's templates against r. The body is
+// rendered before anything is written so a failing template becomes a 500
+// with the error rather than a truncated response.
+func renderTemplateRoute(w http.ResponseWriter, r *http.Request, rc *routeConfig) {
+	td := rc.templateDataFor(r)
+
+	var body bytes.Buffer
+	if err := rc.bodyTmpl.Execute(&body, td); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	for k, t := range rc.headerTmpls {
+		var v strings.Builder
+		if err := t.Execute(&v, td); err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		w.Header().Set(k, v.String())
+	}
+	w.WriteHeader(rc.Status)
+	w.Write(body.Bytes())
+}
+
+// registerRoutes adds the configured routes to mux. ServeMux panics on an
+// invalid or conflicting pattern; that is reported as an error instead.
+func registerRoutes(mux *http.ServeMux, routes []routeConfig) (err error) {
+	for _, rc := range routes {
+		func() {
+			defer func() {
+				if p := recover(); p != nil {
+					err = errors.New(fmt.Sprint(p))
+				}
+			}()
+			mux.HandleFunc(rc.pattern(), routeHandler(rc))
+		}()
+		if err != nil {
+			return fmt.Errorf("route %q: %w", rc.pattern(), err)
+		}
+	}
+	return nil
+}
+
 func main() {
 	port := flag.Int("port", 8080, "Server port")
 	host := flag.String("host", "0.0.0.0", "Server host (0.0.0.0 for all interfaces)")
+	configPath := flag.String("config", "", "JSON file with additional routes")
+	useTLS := flag.Bool("tls", false, "Serve HTTPS")
+	certFile := flag.String("cert", "", "TLS certificate (PEM); self-signed if empty")
+	keyFile := flag.String("key", "", "TLS private key (PEM)")
+	writeCert := flag.String("write-cert", "", "Write the generated self-signed certificate to this file")
+	enableH2 := flag.Bool("h2", true, "Negotiate HTTP/2 over TLS")
+	enableH2C := flag.Bool("h2c", false, "Serve cleartext HTTP/2 (prior knowledge) alongside HTTP/1.1")
+	maxConcurrent := flag.Int("max-concurrent", 0, "Requests allowed in progress before overload handling (0 = unlimited)")
+	overload := flag.String("overload", "reject", "Beyond -max-concurrent: reject (503 at once) or queue (wait up to -queue-timeout, then 503)")
+	queueTimeout := flag.Duration("queue-timeout", time.Second, "Longest a queued request waits for a slot")
+	rateLimit := flag.Float64("rate-limit", 0, "Requests/sec allowed per -rate-key before 429 (0 = unlimited)")
+	rateBurst := flag.Int("rate-burst", 0, "Token bucket size for -rate-limit (default: the rate, at least 1)")
+	rateKey := flag.String("rate-key", "ip", "Rate limit bucket key: ip, route or ip+route")
+	recordN := flag.Int("record", 0, "Keep the last N requests for inspection via /requests (0 disables)")
+	recordBody := flag.Int("record-body", 4096, "Bytes of each request body to keep when recording")
+	connBandwidth := flag.String("conn-bandwidth", "", "Per-connection write cap per second, e.g. 1MB or 256KiB (disables the write timeout)")
+	wsRate := flag.Float64("ws-rate", 10, "Messages/sec published to /ws/broadcast (0 relays client messages only)")
 	flag.Parse()
 
-	startTime = time.Now()
+	if (*certFile == "") != (*keyFile == "") {
+		log.Fatal("-cert and -key must be given together")
+	}
+	if *overload != "reject" && *overload != "queue" {
+		log.Fatalf("-overload: want reject or queue, got %q", *overload)
+	}
+	if *rateKey != "ip" && *rateKey != "route" && *rateKey != "ip+route" {
+		log.Fatalf("-rate-key: want ip, route or ip+route, got %q", *rateKey)
+	}
+	if *rateLimit < 0 || *rateBurst < 0 {
+		log.Fatal("-rate-limit and -rate-burst must not be negative")
+	}
+	// Above a million a second the tick interval rounds down to nothing.
+	if !(*wsRate >= 0 && *wsRate <= 1e6) {
+		log.Fatalf("-ws-rate: want 0 to 1e6 messages/sec, got %g", *wsRate)
+	}
+	if *rateBurst == 0 {
+		*rateBurst = max(int(math.Ceil(*rateLimit)), 1)
+	}
+	var bandwidth int64
+	if *connBandwidth != "" {
+		var err error
+		if bandwidth, err = parseByteSize(*connBandwidth); err != nil || bandwidth <= 0 {
+			log.Fatalf("-conn-bandwidth: invalid value %q", *connBandwidth)
+		}
+	}
+
+	var cfg mockConfig
+	if *configPath != "" {
+		loaded, err := loadConfig(*configPath)
+		if err != nil {
+			log.Fatalf("config: %v", err)
+		}
+		cfg = *loaded
+	}
+
+	resetStartTime()
 
 	// Use all available CPU cores
 	runtime.GOMAXPROCS(runtime.NumCPU())
@@ -93,11 +2292,20 @@ func main() {
 			}
 		}
 
-		time.Sleep(time.Duration(delayMs) * time.Millisecond)
+		// Optional distribution around that delay: ?dist=normal|lognormal|pareto|bimodal
+		lc, err := latencyFromQuery(delayMs, r.URL.Query())
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		delay := lc.sample()
+		time.Sleep(delay)
 
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
-		fmt.Fprintf(w, `{"ok":true,"delay_ms":%d}`, delayMs)
+		fmt.Fprintf(w, `{"ok":true,"delay_ms":%d}`, delay.Milliseconds())
 
 		atomic.AddInt64(&totalLatencyNs, time.Since(start).Nanoseconds())
 	})
@@ -112,7 +2320,7 @@ func main() {
 
 		if r.Body != nil {
 			buf := make([]byte, 1024)
-			n, _ := r.Body.Read(buf)
+			n, _ := io.ReadFull(r.Body, buf)
 			if n > 0 {
 				w.Write(buf[:n])
 			} else {
@@ -123,11 +2331,46 @@ func main() {
 		atomic.AddInt64(&totalLatencyNs, time.Since(start).Nanoseconds())
 	})
 
-	// Stats endpoint - show performance metrics
+	// WebSocket endpoints
+	hub := newWSHub()
+	if *wsRate > 0 {
+		go hub.tick(*wsRate)
+	}
+	mux.HandleFunc("/ws/echo", handleWSEcho)
+	mux.HandleFunc("/ws/broadcast", hub.handleWSBroadcast)
+
+	// In-memory CRUD store. Registered without methods so config routes
+	// such as "GET /api/users/{id}" stay more specific; the example config
+	// keeps its routes under /mock so they do not shadow the store.
+	store := newCRUDStore()
+	mux.HandleFunc("/api/{resource}", store.handleCollection)
+	mux.HandleFunc("/api/{resource}/{id}", store.handleItem)
+
+	// Sized response - /bytes/1048576 returns 1 MiB
+	mux.HandleFunc("GET /bytes/{n}", handleBytes)
+
+	// Server-sent events - /sse/250 emits an event every 250ms
+	mux.HandleFunc("GET /sse/{intervalMs}", handleSSE)
+
+	// gRPC echo service
+	mux.HandleFunc("POST /vayu.mock.v1.Echo/{method}", handleGRPC)
+
+	// Request inspection - see -record
+	var recorder *requestRecorder
+	if *recordN > 0 {
+		recorder = newRequestRecorder(*recordN, max(*recordBody, 0))
+	}
+	mux.HandleFunc("/requests", recorder.handleRequests)
+
+	// Prometheus metrics
+	mux.HandleFunc("GET /metrics", requestsPerPath.handleMetrics)
+
+	// Stats endpoint - show performance metrics. WebSocket, SSE and gRPC
+	// streams count toward avg_latency_us for as long as they stay open.
 	mux.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
 		total := atomic.LoadInt64(&totalRequests)
 		latencyNs := atomic.LoadInt64(&totalLatencyNs)
-		uptime := time.Since(startTime).Seconds()
+		uptime := sinceStart().Seconds()
 
 		avgLatencyUs := float64(0)
 		if total > 0 {
@@ -146,6 +2389,13 @@ func main() {
 			"requests_per_sec": rps,
 			"cpu_cores":        runtime.NumCPU(),
 			"goroutines":       runtime.NumGoroutine(),
+			"injected_faults":  atomic.LoadInt64(&totalFaults),
+			"ws_connections":   atomic.LoadInt64(&wsConnections),
+			"ws_dropped":       atomic.LoadInt64(&hub.dropped),
+			"sse_connections":  atomic.LoadInt64(&sseConnections),
+			"overload_503s":    atomic.LoadInt64(&totalOverloaded),
+			"rate_limit_429s":  atomic.LoadInt64(&totalLimited),
+			"paths":            requestsPerPath.stats(uptime),
 		}
 
 		w.Header().Set("Content-Type", "application/json")
@@ -156,7 +2406,11 @@ func main() {
 	mux.HandleFunc("/reset", func(w http.ResponseWriter, r *http.Request) {
 		atomic.StoreInt64(&totalRequests, 0)
 		atomic.StoreInt64(&totalLatencyNs, 0)
-		startTime = time.Now()
+		atomic.StoreInt64(&totalFaults, 0)
+		atomic.StoreInt64(&totalOverloaded, 0)
+		atomic.StoreInt64(&totalLimited, 0)
+		requestsPerPath.reset()
+		resetStartTime()
 
 		w.Header().Set("Content-Type", "application/json")
 		w.Write([]byte(`{"reset":true}`))
@@ -174,12 +2428,82 @@ func main() {
 		atomic.AddInt64(&totalLatencyNs, time.Since(start).Nanoseconds())
 	})
 
+	// Configured routes share the mux with the built-ins; a route that
+	// duplicates a built-in pattern (e.g. plain "/health") is rejected.
+	if err := registerRoutes(mux, cfg.Routes); err != nil {
+		log.Fatalf("config: %v", err)
+	}
+
+	var handler http.Handler = mux
+	if cfg.Faults != nil {
+		skip := map[string]bool{"/stats": true, "/reset": true, "/requests": true, "GET /metrics": true}
+		for _, rc := range cfg.Routes {
+			if rc.Faults != nil {
+				skip[rc.pattern()] = true
+			}
+		}
+		handler = withFaults(mux, cfg.Faults, skip)
+	}
+	if *maxConcurrent > 0 {
+		limiter := &concurrencyLimiter{
+			slots:   make(chan struct{}, *maxConcurrent),
+			queue:   *overload == "queue",
+			timeout: *queueTimeout,
+		}
+		handler = limiter.middleware(handler)
+	}
+	// Rate limiting runs first so rejected requests never take a slot.
+	if *rateLimit > 0 {
+		handler = newRateLimiter(mux, *rateLimit, *rateBurst, *rateKey).middleware(handler)
+	}
+	// Record outside fault injection so faulted requests are captured too.
+	if recorder != nil {
+		handler = recorder.middleware(handler)
+	}
+	handler = requestsPerPath.middleware(mux, handler)
+
 	server := &http.Server{
 		Addr:           fmt.Sprintf("%s:%d", *host, *port),
-		Handler:        mux,
+		Handler:        handler,
 		ReadTimeout:    5 * time.Second,
 		WriteTimeout:   5 * time.Second,
 		MaxHeaderBytes: 1 << 20,
+		Protocols:      new(http.Protocols),
+	}
+	server.Protocols.SetHTTP1(true)
+
+	// A throttled response may legitimately take longer than the write
+	// timeout; the throttle, not the server, decides how long it takes.
+	if bandwidth > 0 {
+		server.WriteTimeout = 0
+	}
+
+	scheme := "http"
+	curlFlags := ""
+	if *useTLS {
+		scheme, curlFlags = "https", "-k "
+		server.Protocols.SetHTTP2(*enableH2)
+
+		if *certFile != "" {
+			cert, err := tls.LoadX509KeyPair(*certFile, *keyFile)
+			if err != nil {
+				log.Fatalf("tls: %v", err)
+			}
+			server.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+		} else {
+			cert, certPEM, err := selfSignedCert(*host)
+			if err != nil {
+				log.Fatalf("tls: %v", err)
+			}
+			if *writeCert != "" {
+				if err := os.WriteFile(*writeCert, certPEM, 0o644); err != nil {
+					log.Fatalf("tls: %v", err)
+				}
+			}
+			server.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+		}
+	} else if *enableH2C {
+		server.Protocols.SetUnencryptedHTTP2(true)
 	}
 
 	testHost := *host
@@ -193,19 +2517,73 @@ func main() {
 	fmt.Printf("╠══════════════════════════════════════════════════════════════╣\n")
 	fmt.Printf("║  Host:      %-48s ║\n", *host)
 	fmt.Printf("║  Port:      %-48d ║\n", *port)
+	fmt.Printf("║  Protocols: %-48s ║\n", protocolList(server.Protocols, *useTLS))
+	if bandwidth > 0 {
+		fmt.Printf("║  Bandwidth: %-48s ║\n", *connBandwidth+"/s per connection")
+	}
+	if *maxConcurrent > 0 {
+		fmt.Printf("║  Max conc.: %-48s ║\n", fmt.Sprintf("%d (%s)", *maxConcurrent, *overload))
+	}
+	if *rateLimit > 0 {
+		fmt.Printf("║  Rate:      %-48s ║\n", fmt.Sprintf("%g/s, burst %d, per %s", *rateLimit, *rateBurst, *rateKey))
+	}
 	fmt.Printf("║  CPU Cores: %-48d ║\n", runtime.NumCPU())
 	fmt.Printf("║  PID:       %-48d ║\n", os.Getpid())
 	fmt.Printf("╠══════════════════════════════════════════════════════════════╣\n")
 	fmt.Printf("║  Endpoints:                                                  ║\n")
 	fmt.Printf("║    GET  /health  - Health check (instant)                    ║\n")
 	fmt.Printf("║    GET  /fast    - Fast response (~0ms)                      ║\n")
-	fmt.Printf("║    GET  /slow/N  - Delayed response (N ms)                   ║\n")
+	fmt.Printf("║    GET  /slow/N  - Delayed response (N ms, ?jitter=J)        ║\n")
 	fmt.Printf("║    POST /echo    - Echo request body                         ║\n")
 	fmt.Printf("║    GET  /stats   - Performance statistics                    ║\n")
+	fmt.Printf("║    GET  /metrics - Prometheus metrics                        ║\n")
+	if recorder != nil {
+		fmt.Printf("║    %-57s ║\n", fmt.Sprintf("GET  /requests - Last %d recorded requests", *recordN))
+	}
+	fmt.Printf("║    *    /api/R[/ID] - In-memory CRUD store                   ║\n")
+	fmt.Printf("║    GET  /bytes/N - N-byte response body                      ║\n")
+	fmt.Printf("║    GET  /sse/N   - Server-sent event every N ms              ║\n")
+	fmt.Printf("║    WS   /ws/echo - WebSocket echo                            ║\n")
+	fmt.Printf("║    %-57s ║\n", fmt.Sprintf("WS   /ws/broadcast - Fan-out (%g msg/s)", *wsRate))
+	fmt.Printf("║    gRPC vayu.mock.v1.Echo - Echo service (h2/h2c only)       ║\n")
 	fmt.Printf("║    GET  /reset   - Reset statistics                          ║\n")
+	if len(cfg.Routes) > 0 {
+		fmt.Printf("╠══════════════════════════════════════════════════════════════╣\n")
+		fmt.Printf("║  Config:    %-48s ║\n", *configPath)
+		for _, rc := range cfg.Routes {
+			fmt.Printf("║    %-57s ║\n", rc.pattern())
+		}
+	}
 	fmt.Printf("╠══════════════════════════════════════════════════════════════╣\n")
-	fmt.Printf("║  Test with: curl http://%s:%d/health                 ║\n", testHost, *port)
+	fmt.Printf("║  Test with: curl %s%s://%s:%d/health                 ║\n", curlFlags, scheme, testHost, *port)
 	fmt.Printf("╚══════════════════════════════════════════════════════════════╝\n")
 
-	log.Fatal(server.ListenAndServe())
+	ln, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if bandwidth > 0 {
+		ln = throttledListener{Listener: ln, bytesPerSec: bandwidth}
+	}
+
+	if *useTLS {
+		// Certificates are already in TLSConfig.
+		log.Fatal(server.ServeTLS(ln, "", ""))
+	}
+	log.Fatal(server.Serve(ln))
+}
+
+// protocolList describes what the server will negotiate, for the banner.
+func protocolList(p *http.Protocols, useTLS bool) string {
+	list := []string{"HTTP/1.1"}
+	if useTLS && p.HTTP2() {
+		list = append(list, "h2")
+	}
+	if !useTLS && p.UnencryptedHTTP2() {
+		list = append(list, "h2c")
+	}
+	if useTLS {
+		return strings.Join(list, ", ") + " over TLS"
+	}
+	return strings.Join(list, ", ")
 }
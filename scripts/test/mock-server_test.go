@@ -0,0 +1,233 @@
+// Run with: go test mock-server.go mock-server_test.go
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newCRUDMux() *http.ServeMux {
+	store := newCRUDStore()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/{resource}", store.handleCollection)
+	mux.HandleFunc("/api/{resource}/{id}", store.handleItem)
+	return mux
+}
+
+// do sends a request to mux and decodes a JSON object response, if any.
+func do(t *testing.T, mux http.Handler, method, path, body string) (int, map[string]any) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+	var got map[string]any
+	if strings.HasPrefix(rec.Body.String(), "{") {
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatalf("%s %s: decoding %q: %v", method, path, rec.Body, err)
+		}
+	}
+	return rec.Code, got
+}
+
+func TestCRUDLifecycle(t *testing.T) {
+	mux := newCRUDMux()
+
+	code, item := do(t, mux, "POST", "/api/users", `{"name":"a"}`)
+	if code != http.StatusCreated || item["id"] != "1" {
+		t.Fatalf("create: got %d %v, want 201 with id \"1\"", code, item)
+	}
+
+	code, item = do(t, mux, "GET", "/api/users/1", "")
+	if code != http.StatusOK || item["name"] != "a" {
+		t.Fatalf("read: got %d %v", code, item)
+	}
+
+	code, item = do(t, mux, "PUT", "/api/users/1", `{"name":"b"}`)
+	if code != http.StatusOK || item["name"] != "b" || item["id"] != "1" {
+		t.Fatalf("overwrite: got %d %v", code, item)
+	}
+	if _, item = do(t, mux, "GET", "/api/users/1", ""); item["name"] != "b" {
+		t.Fatalf("read after overwrite: got %v", item)
+	}
+
+	if code, _ = do(t, mux, "DELETE", "/api/users/1", ""); code != http.StatusNoContent {
+		t.Fatalf("delete: got %d, want 204", code)
+	}
+	if code, _ = do(t, mux, "GET", "/api/users/1", ""); code != http.StatusNotFound {
+		t.Fatalf("read after delete: got %d, want 404", code)
+	}
+}
+
+func TestCRUDAutoIDSkipsTakenIDs(t *testing.T) {
+	mux := newCRUDMux()
+
+	do(t, mux, "POST", "/api/users", `{"id":1,"name":"a"}`)
+	do(t, mux, "PUT", "/api/users/2", `{"name":"b"}`)
+	code, item := do(t, mux, "POST", "/api/users", `{"name":"c"}`)
+	if code != http.StatusCreated || item["id"] != "3" {
+		t.Fatalf("auto id: got %d %v, want 201 with id \"3\"", code, item)
+	}
+
+	for id, name := range map[string]string{"1": "a", "2": "b", "3": "c"} {
+		if _, item := do(t, mux, "GET", "/api/users/"+id, ""); item["name"] != name || item["id"] != id {
+			t.Errorf("GET /api/users/%s: got %v, want name %q", id, item, name)
+		}
+	}
+
+	if code, _ = do(t, mux, "POST", "/api/users", `{"id":"2"}`); code != http.StatusConflict {
+		t.Errorf("duplicate id: got %d, want 409", code)
+	}
+}
+
+func TestCRUDRejectsInvalidID(t *testing.T) {
+	mux := newCRUDMux()
+	for _, body := range []string{`{"id":null}`, `{"id":{"a":1}}`, `{"id":[1]}`, `{"id":true}`, `{"id":""}`} {
+		if code, _ := do(t, mux, "POST", "/api/users", body); code != http.StatusBadRequest {
+			t.Errorf("POST %s: got %d, want 400", body, code)
+		}
+	}
+}
+
+func TestLatencyFromQuery(t *testing.T) {
+	tests := []struct {
+		name    string
+		delayMs int
+		query   string
+		wantErr bool
+		check   func(*latencyConfig) bool
+	}{
+		{"plain", 100, "", false, func(lc *latencyConfig) bool { return lc.Distribution == "fixed" && lc.MeanMs == 100 }},
+		{"negative delay is 0", -5, "", false, func(lc *latencyConfig) bool { return lc.MeanMs == 0 }},
+		{"pareto at 0 gets 1ms minimum", 0, "dist=pareto", false, func(lc *latencyConfig) bool { return lc.MinMs == 1 }},
+		{"lognormal at 0 gets 1ms median", 0, "dist=lognormal", false, func(lc *latencyConfig) bool { return lc.MedianMs == 1 }},
+		{"unknown dist", 100, "dist=uniform", true, nil},
+		{"bad number", 100, "jitter=abc", true, nil},
+		{"negative jitter", 100, "jitter=-1", true, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, _ := url.ParseQuery(tt.query)
+			lc, err := latencyFromQuery(tt.delayMs, q)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got %+v, want an error", lc)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.check(lc) {
+				t.Errorf("unexpected config %+v", lc)
+			}
+		})
+	}
+}
+
+func TestLatencySampleClampsJitter(t *testing.T) {
+	// Jitter larger than the delay must never yield a negative sleep.
+	lc := &latencyConfig{Distribution: "fixed", MeanMs: 5, JitterMs: 50}
+	zeros := 0
+	for range 1000 {
+		d := lc.sample()
+		if d < 0 || d > 55*time.Millisecond {
+			t.Fatalf("sample %v outside [0, 55ms]", d)
+		}
+		if d == 0 {
+			zeros++
+		}
+	}
+	if zeros == 0 {
+		t.Error("no sample was clamped to 0")
+	}
+
+	lc = &latencyConfig{Distribution: "fixed", MeanMs: 100, JitterMs: 50, MaxMs: 120}
+	for range 1000 {
+		if d := lc.sample(); d > 120*time.Millisecond {
+			t.Fatalf("sample %v above maxMs 120", d)
+		}
+	}
+}
+
+func TestTemplateRoute(t *testing.T) {
+	serve := func(body, reqBody string) *httptest.ResponseRecorder {
+		t.Helper()
+		rc := routeConfig{Method: "POST", Path: "/users/{id}", Status: http.StatusOK, Body: body, Template: true}
+		if err := rc.compileTemplates(); err != nil {
+			t.Fatal(err)
+		}
+		mux := http.NewServeMux()
+		mux.HandleFunc(rc.pattern(), routeHandler(rc))
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest("POST", "/users/7?q=go", strings.NewReader(reqBody)))
+		return rec
+	}
+
+	rec := serve(`{"id":{{json .Params.id}},"q":{{json (.Query.Get "q")}},"name":{{json .Body.name}}}`, `{"name":"ada"}`)
+	if got, want := rec.Body.String(), `{"id":"7","q":"go","name":"ada"}`; rec.Code != http.StatusOK || got != want {
+		t.Errorf("params/query/body: got %d %s, want 200 %s", rec.Code, got, want)
+	}
+
+	rec = serve(`{"name":"{{.Body.name}}"}`, `{}`)
+	if rec.Code != http.StatusInternalServerError || !strings.Contains(rec.Body.String(), `no entry for key`) {
+		t.Errorf("missing key: got %d %s, want 500 naming the key", rec.Code, rec.Body)
+	}
+
+	rec = serve(`{"name":{{json (index .Body "name")}}}`, `not json`)
+	if got := rec.Body.String(); rec.Code != http.StatusOK || got != `{"name":null}` {
+		t.Errorf("optional key: got %d %s, want 200 {\"name\":null}", rec.Code, got)
+	}
+}
+
+func TestMetricsStatusCodes(t *testing.T) {
+	resetStartTime() // main sets it; /metrics reports uptime
+	reg := newMetricsRegistry()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/s299", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(299) })
+	mux.HandleFunc("/s799", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(799) })
+	mux.HandleFunc("/upgrade", func(w http.ResponseWriter, r *http.Request) {
+		conn, _, err := http.NewResponseController(w).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+	})
+	mux.HandleFunc("/panic", func(w http.ResponseWriter, r *http.Request) { panic(http.ErrAbortHandler) })
+	mux.HandleFunc("GET /metrics", reg.handleMetrics)
+	srv := httptest.NewServer(reg.middleware(mux, mux))
+	defer srv.Close()
+
+	for _, path := range []string{"/s299", "/s799", "/upgrade", "/panic"} {
+		// POST so the client does not retry /panic after the EOF.
+		req, _ := http.NewRequest("POST", srv.URL+path, nil)
+		if path == "/upgrade" {
+			req.Header.Set("Connection", "Upgrade")
+			req.Header.Set("Upgrade", "websocket")
+		}
+		if resp, err := http.DefaultClient.Do(req); err == nil {
+			resp.Body.Close()
+		}
+	}
+
+	resp, err := http.Get(srv.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	for _, want := range []string{
+		`mock_http_requests_total{path="/s299",code="299"} 1`,
+		`mock_http_requests_total{path="/s799",code="799"} 1`,
+		`mock_http_requests_total{path="/upgrade",code="101"} 1`,
+		`mock_http_requests_total{path="/panic",code="aborted"} 1`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("/metrics is missing %s\n%s", want, body)
+		}
+	}
+}